@@ -0,0 +1,127 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package backend defines a provider-agnostic interface for the model
+// backends a PromptSession can stream completions from. The goal is to let
+// operators point the assistant at a local model (llama.cpp, whisper, ...)
+// running out-of-process, selected per-request, without touching the
+// session loop itself.
+package backend
+
+import (
+	"context"
+	"fmt"
+)
+
+// PartKind identifies the payload carried by a Part.
+type PartKind int
+
+const (
+	PartText PartKind = iota
+	PartFunctionCall
+	PartFunctionResponse
+	PartInlineData
+)
+
+// FunctionCall is a model-requested invocation of a tool.
+type FunctionCall struct {
+	Name string
+	Args map[string]any
+}
+
+// FunctionResponse is the result of running a FunctionCall.
+type FunctionResponse struct {
+	Name     string
+	Response map[string]any
+}
+
+// InlineData is a non-text part, e.g. an image or audio blob.
+type InlineData struct {
+	MimeType string
+	Data     []byte
+}
+
+// Part is one piece of a Message. Exactly one of the fields matching Kind
+// is populated.
+type Part struct {
+	Kind             PartKind
+	Text             string
+	FunctionCall     *FunctionCall
+	FunctionResponse *FunctionResponse
+	InlineData       *InlineData
+}
+
+// Message is a provider-agnostic turn in a conversation.
+type Message struct {
+	Role  string
+	Parts []Part
+}
+
+// Tool is a single function a provider may call.
+type Tool struct {
+	Name        string
+	Description string
+	Parameters  map[string]any
+}
+
+// Usage reports token accounting for a single generation.
+type Usage struct {
+	InputTokens  int
+	OutputTokens int
+}
+
+// Chunk is one unit streamed back from a Provider. A stream ends when the
+// channel returned by StreamGenerate is closed; Err is set on the final
+// chunk if generation failed partway through. Usage is set on at most one
+// chunk per stream, carrying that call's total token counts; callers sum it
+// across calls (e.g. function-calling round trips), so a Provider must
+// never report it more than once per StreamGenerate.
+type Chunk struct {
+	Text         string
+	FunctionCall *FunctionCall
+	Usage        *Usage
+	Err          error
+}
+
+// Provider streams a completion for a conversation. Implementations must
+// close the returned channel once the stream ends or ctx is cancelled.
+type Provider interface {
+	StreamGenerate(ctx context.Context, messages []Message, tools []Tool, sysPrompt string) (<-chan Chunk, error)
+
+	// Close releases any connection or background resources the provider
+	// holds. Get builds a fresh Provider per call, so callers must close it
+	// once the session that obtained it is done with it.
+	Close() error
+}
+
+// Factory builds a Provider from the value given on a per-request backend
+// selector (e.g. a gRPC address for the "grpc" provider).
+type Factory func(selector string) (Provider, error)
+
+var factories = map[string]Factory{}
+
+// Register makes a provider available under name for later lookup with Get.
+// Providers call this from an init() function.
+func Register(name string, factory Factory) {
+	factories[name] = factory
+}
+
+// Get builds the named provider, passing selector through to its Factory.
+func Get(name, selector string) (Provider, error) {
+	factory, ok := factories[name]
+	if !ok {
+		return nil, fmt.Errorf("backend: no provider registered under %q", name)
+	}
+	return factory(selector)
+}
@@ -0,0 +1,163 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"google.golang.org/api/iterator"
+	"google.golang.org/genai"
+)
+
+// geminiModel is the model used by the built-in Gemini provider. It matches
+// what PromptSession.Run dialed directly before providers existed.
+const geminiModel = "models/gemini-2.0-flash"
+
+func init() {
+	Register("gemini", func(apiKey string) (Provider, error) {
+		return NewGeminiProvider(apiKey)
+	})
+}
+
+// GeminiProvider wraps genai.Client behind the Provider interface.
+type GeminiProvider struct {
+	client *genai.Client
+}
+
+// NewGeminiProvider dials the Gemini API with the given key. selector is
+// the apiKey to use, or "" to fall back to config.GetConfig().GeminiKey via
+// the caller.
+func NewGeminiProvider(apiKey string) (*GeminiProvider, error) {
+	client, err := genai.NewClient(context.Background(), &genai.ClientConfig{
+		APIKey:  apiKey,
+		Backend: genai.BackendGeminiAPI,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &GeminiProvider{client: client}, nil
+}
+
+// Close is a no-op: genai.Client has no handles of its own to release.
+func (p *GeminiProvider) Close() error {
+	return nil
+}
+
+func (p *GeminiProvider) StreamGenerate(ctx context.Context, messages []Message, tools []Tool, sysPrompt string) (<-chan Chunk, error) {
+	temperature := float64(0.5)
+	one := int64(1)
+	s := p.client.Models.GenerateContentStream(ctx, geminiModel, toGenaiContents(messages), &genai.GenerateContentConfig{
+		SystemInstruction: &genai.Content{Parts: []*genai.Part{{Text: sysPrompt}}},
+		Temperature:       &temperature,
+		CandidateCount:    &one,
+		Tools:             toGenaiTools(tools),
+	})
+
+	out := make(chan Chunk)
+	go func() {
+		defer close(out)
+		var usage *Usage
+		for resp, err := range s {
+			if errors.Is(err, iterator.Done) {
+				break
+			}
+			if err != nil {
+				out <- Chunk{Err: err}
+				return
+			}
+			if resp.UsageMetadata != nil {
+				u := Usage{}
+				if resp.UsageMetadata.PromptTokenCount != nil {
+					u.InputTokens = int(*resp.UsageMetadata.PromptTokenCount)
+				}
+				if resp.UsageMetadata.CandidatesTokenCount != nil {
+					u.OutputTokens = int(*resp.UsageMetadata.CandidatesTokenCount)
+				}
+				usage = &u
+			}
+			if len(resp.Candidates) == 0 {
+				continue
+			}
+			for _, part := range resp.Candidates[0].Content.Parts {
+				if part.Text != "" {
+					out <- Chunk{Text: part.Text}
+				}
+				if part.FunctionCall != nil {
+					out <- Chunk{FunctionCall: &FunctionCall{Name: part.FunctionCall.Name, Args: part.FunctionCall.Args}}
+				}
+			}
+		}
+		if usage != nil {
+			out <- Chunk{Usage: usage}
+		}
+	}()
+	return out, nil
+}
+
+func toGenaiContents(messages []Message) []*genai.Content {
+	contents := make([]*genai.Content, 0, len(messages))
+	for _, m := range messages {
+		parts := make([]*genai.Part, 0, len(m.Parts))
+		for _, p := range m.Parts {
+			switch p.Kind {
+			case PartText:
+				parts = append(parts, &genai.Part{Text: p.Text})
+			case PartFunctionCall:
+				parts = append(parts, &genai.Part{FunctionCall: &genai.FunctionCall{Name: p.FunctionCall.Name, Args: p.FunctionCall.Args}})
+			case PartFunctionResponse:
+				parts = append(parts, &genai.Part{FunctionResponse: &genai.FunctionResponse{Name: p.FunctionResponse.Name, Response: p.FunctionResponse.Response}})
+			case PartInlineData:
+				parts = append(parts, &genai.Part{InlineData: &genai.Blob{MIMEType: p.InlineData.MimeType, Data: p.InlineData.Data}})
+			}
+		}
+		contents = append(contents, &genai.Content{Role: m.Role, Parts: parts})
+	}
+	return contents
+}
+
+func toGenaiTools(tools []Tool) []*genai.Tool {
+	if len(tools) == 0 {
+		return nil
+	}
+	decls := make([]*genai.FunctionDeclaration, 0, len(tools))
+	for _, t := range tools {
+		decls = append(decls, &genai.FunctionDeclaration{
+			Name:        t.Name,
+			Description: t.Description,
+			Parameters:  mapToSchema(t.Parameters),
+		})
+	}
+	return []*genai.Tool{{FunctionDeclarations: decls}}
+}
+
+// mapToSchema rebuilds the *genai.Schema a Tool.Parameters map was derived
+// from (see assistant.toBackendTools), so Gemini still sees the full
+// argument schema instead of just name/description.
+func mapToSchema(params map[string]any) *genai.Schema {
+	if len(params) == 0 {
+		return nil
+	}
+	j, err := json.Marshal(params)
+	if err != nil {
+		return nil
+	}
+	var schema genai.Schema
+	if err := json.Unmarshal(j, &schema); err != nil {
+		return nil
+	}
+	return &schema
+}
@@ -0,0 +1,158 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+
+	"github.com/Katharine/tiny-assistant/service/assistant/backend/backendpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func init() {
+	Register("grpc", func(address string) (Provider, error) {
+		return NewGRPCProvider(address)
+	})
+}
+
+// GRPCProvider dials an out-of-process model backend (llama.cpp, whisper,
+// ...) that implements the tinyassistant.backend.Backend service described
+// in backend/proto/backend.proto.
+type GRPCProvider struct {
+	conn   *grpc.ClientConn
+	client backendpb.BackendClient
+}
+
+// NewGRPCProvider dials address, which must be reachable and implement the
+// Backend service. The connection is kept open for the lifetime of the
+// provider.
+func NewGRPCProvider(address string) (*GRPCProvider, error) {
+	conn, err := grpc.NewClient(address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+	return &GRPCProvider{conn: conn, client: backendpb.NewBackendClient(conn)}, nil
+}
+
+// Close tears down the dialed gRPC connection. NewGRPCProvider dials fresh
+// on every request, so callers must close the Provider once the session
+// that obtained it is done with it or the connection (and its background
+// goroutines) leaks.
+func (p *GRPCProvider) Close() error {
+	return p.conn.Close()
+}
+
+func (p *GRPCProvider) StreamGenerate(ctx context.Context, messages []Message, tools []Tool, sysPrompt string) (<-chan Chunk, error) {
+	req, err := toPredictRequest(messages, tools, sysPrompt)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := p.client.PredictStream(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan Chunk)
+	go func() {
+		defer close(out)
+		for {
+			chunk, err := stream.Recv()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				out <- Chunk{Err: err}
+				return
+			}
+			c := Chunk{Text: chunk.Text}
+			if chunk.FunctionCall != nil {
+				var args map[string]any
+				_ = json.Unmarshal(chunk.FunctionCall.ArgsJson, &args)
+				c.FunctionCall = &FunctionCall{Name: chunk.FunctionCall.Name, Args: args}
+			}
+			// Usage is only meaningful once, on the final chunk of the
+			// stream (see Chunk.Usage): StreamGenerate is called once per
+			// turn, and the session sums each call's usage into its
+			// running total, so reporting it more than once here would
+			// double-count.
+			if chunk.Done && (chunk.InputTokens != 0 || chunk.OutputTokens != 0) {
+				c.Usage = &Usage{InputTokens: int(chunk.InputTokens), OutputTokens: int(chunk.OutputTokens)}
+			}
+			out <- c
+			if chunk.Done {
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+func toPredictRequest(messages []Message, tools []Tool, sysPrompt string) (*backendpb.PredictRequest, error) {
+	pbMessages := make([]*backendpb.Message, 0, len(messages))
+	for _, m := range messages {
+		pbParts := make([]*backendpb.Part, 0, len(m.Parts))
+		for _, p := range m.Parts {
+			pbPart, err := toPBPart(p)
+			if err != nil {
+				return nil, err
+			}
+			pbParts = append(pbParts, pbPart)
+		}
+		pbMessages = append(pbMessages, &backendpb.Message{Role: m.Role, Parts: pbParts})
+	}
+
+	pbTools := make([]*backendpb.Tool, 0, len(tools))
+	for _, t := range tools {
+		paramsJSON, err := json.Marshal(t.Parameters)
+		if err != nil {
+			return nil, err
+		}
+		pbTools = append(pbTools, &backendpb.Tool{Name: t.Name, Description: t.Description, ParametersJson: paramsJSON})
+	}
+
+	return &backendpb.PredictRequest{Messages: pbMessages, Tools: pbTools, SystemPrompt: sysPrompt}, nil
+}
+
+func toPBPart(p Part) (*backendpb.Part, error) {
+	switch p.Kind {
+	case PartText:
+		return &backendpb.Part{Payload: &backendpb.Part_Text{Text: p.Text}}, nil
+	case PartFunctionCall:
+		argsJSON, err := json.Marshal(p.FunctionCall.Args)
+		if err != nil {
+			return nil, err
+		}
+		return &backendpb.Part{Payload: &backendpb.Part_FunctionCall{FunctionCall: &backendpb.FunctionCall{
+			Name: p.FunctionCall.Name, ArgsJson: argsJSON,
+		}}}, nil
+	case PartFunctionResponse:
+		respJSON, err := json.Marshal(p.FunctionResponse.Response)
+		if err != nil {
+			return nil, err
+		}
+		return &backendpb.Part{Payload: &backendpb.Part_FunctionResponse{FunctionResponse: &backendpb.FunctionResponse{
+			Name: p.FunctionResponse.Name, ResponseJson: respJSON,
+		}}}, nil
+	case PartInlineData:
+		return &backendpb.Part{Payload: &backendpb.Part_InlineData{InlineData: &backendpb.InlineData{
+			MimeType: p.InlineData.MimeType, Data: p.InlineData.Data,
+		}}}, nil
+	default:
+		return &backendpb.Part{}, nil
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package assistant
+
+import (
+	"encoding/json"
+
+	"github.com/Katharine/tiny-assistant/service/assistant/backend"
+	"google.golang.org/genai"
+)
+
+// toBackendMessages adapts the genai.Content turns kept by PromptSession
+// (and persisted via storeThread) into the provider-agnostic type consumed
+// by backend.Provider.StreamGenerate.
+func toBackendMessages(messages []*genai.Content) []backend.Message {
+	result := make([]backend.Message, 0, len(messages))
+	for _, m := range messages {
+		parts := make([]backend.Part, 0, len(m.Parts))
+		for _, p := range m.Parts {
+			switch {
+			case p.Text != "":
+				parts = append(parts, backend.Part{Kind: backend.PartText, Text: p.Text})
+			case p.FunctionCall != nil:
+				parts = append(parts, backend.Part{
+					Kind:         backend.PartFunctionCall,
+					FunctionCall: &backend.FunctionCall{Name: p.FunctionCall.Name, Args: p.FunctionCall.Args},
+				})
+			case p.FunctionResponse != nil:
+				parts = append(parts, backend.Part{
+					Kind: backend.PartFunctionResponse,
+					FunctionResponse: &backend.FunctionResponse{
+						Name:     p.FunctionResponse.Name,
+						Response: p.FunctionResponse.Response,
+					},
+				})
+			case p.InlineData != nil:
+				parts = append(parts, backend.Part{
+					Kind:       backend.PartInlineData,
+					InlineData: &backend.InlineData{MimeType: p.InlineData.MIMEType, Data: p.InlineData.Data},
+				})
+			}
+		}
+		result = append(result, backend.Message{Role: m.Role, Parts: parts})
+	}
+	return result
+}
+
+// toBackendTools adapts the genai function declarations functions.GetFunctionDefinitionsForCapabilities
+// returns into backend.Tool, so callers of functions.CallFunction keep working across backends.
+func toBackendTools(decls []*genai.FunctionDeclaration) []backend.Tool {
+	tools := make([]backend.Tool, 0, len(decls))
+	for _, d := range decls {
+		tools = append(tools, backend.Tool{
+			Name:        d.Name,
+			Description: d.Description,
+			Parameters:  schemaToMap(d.Parameters),
+		})
+	}
+	return tools
+}
+
+// schemaToMap round-trips a genai.Schema through JSON so it can travel as
+// backend.Tool.Parameters, which every Provider (including the gRPC one,
+// which just re-marshals it into ParametersJson) treats as opaque.
+func schemaToMap(schema *genai.Schema) map[string]any {
+	if schema == nil {
+		return nil
+	}
+	j, err := json.Marshal(schema)
+	if err != nil {
+		return nil
+	}
+	var m map[string]any
+	if err := json.Unmarshal(j, &m); err != nil {
+		return nil
+	}
+	return m
+}
@@ -0,0 +1,120 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package assistant
+
+import (
+	"sync"
+	"time"
+)
+
+// deadlineTimer is a resettable, once-fired alarm. It wraps time.AfterFunc
+// so PromptSession can arm independent read/write/turn deadlines that
+// compose with the request's context.Context without tearing down the
+// whole HTTP handler when one of them expires.
+//
+// timer.Reset only safely re-arms a timer that hasn't fired yet, so reset
+// guards every access with mu and, if the timer already fired, replaces it
+// and fired with a fresh pair rather than closing fired a second time.
+type deadlineTimer struct {
+	mu    sync.Mutex
+	timer *time.Timer
+	fired chan struct{}
+}
+
+// newDeadlineTimer arms a timer that closes its channel after d. A
+// non-positive d disables the timer; its channel is never closed.
+func newDeadlineTimer(d time.Duration) *deadlineTimer {
+	dt := &deadlineTimer{}
+	dt.arm(d)
+	return dt
+}
+
+// arm replaces dt's timer and channel with a fresh pair good for d, or
+// disables the timer entirely for a non-positive d. Callers must hold mu.
+func (dt *deadlineTimer) arm(d time.Duration) {
+	fired := make(chan struct{})
+	dt.fired = fired
+	if d <= 0 {
+		dt.timer = nil
+		return
+	}
+	dt.timer = time.AfterFunc(d, func() { close(fired) })
+}
+
+// C returns the channel that closes once the deadline expires.
+func (dt *deadlineTimer) C() <-chan struct{} {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+	return dt.fired
+}
+
+// reset pushes the deadline d further into the future. If the timer already
+// fired (or was never armed), it is rebuilt from scratch instead of being
+// reused, so the old, already-closed fired channel is never closed again.
+func (dt *deadlineTimer) reset(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+	if dt.timer != nil && dt.timer.Reset(d) {
+		return
+	}
+	dt.arm(d)
+}
+
+func (dt *deadlineTimer) stop() {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+	if dt.timer != nil {
+		dt.timer.Stop()
+	}
+}
+
+// SetReadDeadline re-arms the idle-read timeout: if no bytes arrive from
+// the client within d, the connection is considered idle and Run tears it
+// down with websocket.StatusPolicyViolation.
+func (ps *PromptSession) SetReadDeadline(d time.Duration) {
+	ps.readDeadline.reset(d)
+}
+
+// SetWriteDeadline re-arms the idle-write timeout, covering the case where
+// the upstream generation stalls and nothing is written to the client for
+// d.
+func (ps *PromptSession) SetWriteDeadline(d time.Duration) {
+	ps.writeDeadline.reset(d)
+}
+
+// ExtendTurnDeadline pushes out the current turn's overall budget. Callers
+// with a long-running function call in flight should call this before
+// invoking it so the turn isn't killed out from under them.
+func (ps *PromptSession) ExtendTurnDeadline(d time.Duration) {
+	ps.turnDeadline.reset(d)
+}
+
+// ExtendWriteDeadline pushes out the idle-write timeout. Callers with a
+// long-running function call in flight should call this before invoking it,
+// since nothing is written to the client's socket for the call's duration.
+func (ps *PromptSession) ExtendWriteDeadline(d time.Duration) {
+	ps.writeDeadline.reset(d)
+}
+
+// ExtendReadDeadline pushes out the idle-read timeout. Callers with a
+// long-running function call in flight should call this before invoking it,
+// since no inbound chunk arrives from the model to reset it for the call's
+// duration.
+func (ps *PromptSession) ExtendReadDeadline(d time.Duration) {
+	ps.readDeadline.reset(d)
+}
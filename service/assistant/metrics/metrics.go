@@ -0,0 +1,104 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metrics holds the Prometheus collectors PromptSession reports
+// against across the lifetime of a prompt. Register its handler on the
+// service's HTTP mux with RegisterHandler.
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	TokensTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "assistant_tokens_total",
+		Help: "Tokens consumed per model, split by direction.",
+	}, []string{"model", "direction"})
+
+	FunctionCallLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "assistant_function_call_latency_seconds",
+		Help:    "Latency of functions.CallFunction/CallAction invocations.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"function"})
+
+	FunctionCallErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "assistant_function_call_errors_total",
+		Help: "Function calls that returned an error.",
+	}, []string{"function"})
+
+	IterationsPerSession = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "assistant_iterations_per_session",
+		Help:    "Number of generate/function-call round trips per prompt session.",
+		Buckets: prometheus.LinearBuckets(1, 1, 12),
+	})
+
+	ConnectionDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "assistant_connection_duration_seconds",
+		Help:    "Wall-clock duration of a PromptSession's WebSocket connection.",
+		Buckets: prometheus.ExponentialBuckets(0.5, 2, 12),
+	})
+
+	ThreadRestoreTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "assistant_thread_restore_total",
+		Help: "Thread restores from Redis, split by hit/miss.",
+	}, []string{"result"})
+)
+
+// RecordTokens records token usage for one generation call.
+func RecordTokens(model string, inputTokens, outputTokens int) {
+	TokensTotal.WithLabelValues(model, "input").Add(float64(inputTokens))
+	TokensTotal.WithLabelValues(model, "output").Add(float64(outputTokens))
+}
+
+// ObserveFunctionCall records the latency of a function/action call and,
+// if it failed, counts the error.
+func ObserveFunctionCall(name string, d time.Duration, err error) {
+	FunctionCallLatency.WithLabelValues(name).Observe(d.Seconds())
+	if err != nil {
+		FunctionCallErrorsTotal.WithLabelValues(name).Inc()
+	}
+}
+
+// ObserveIterations records how many generate/function-call round trips a
+// finished session took.
+func ObserveIterations(n int) {
+	IterationsPerSession.Observe(float64(n))
+}
+
+// ObserveConnectionDuration records how long a session's WebSocket stayed
+// open.
+func ObserveConnectionDuration(d time.Duration) {
+	ConnectionDuration.Observe(d.Seconds())
+}
+
+// RecordThreadRestore counts whether a restoreThread call found a thread to
+// resume.
+func RecordThreadRestore(hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	ThreadRestoreTotal.WithLabelValues(result).Inc()
+}
+
+// RegisterHandler mounts the Prometheus scrape endpoint at /metrics.
+func RegisterHandler(mux *http.ServeMux) {
+	mux.Handle("/metrics", promhttp.Handler())
+}
@@ -0,0 +1,177 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package assistant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/Katharine/tiny-assistant/service/assistant/config"
+	"github.com/redis/go-redis/v9"
+	"nhooyr.io/websocket"
+)
+
+// frameEnd marks the end of a session's stream. It is never written to a
+// live websocket; it only tells HandleResume when to stop tailing.
+const frameEnd byte = 'x'
+
+func sessionStreamKey(threadId uuid.UUID) string {
+	return "session:" + threadId.String()
+}
+
+// publishFrame appends a c/f/d/t frame to the session's Redis Stream, so a
+// reconnecting client can replay it via HandleResume, then makes a
+// best-effort write of the same frame to the client's live socket. The
+// stream's own monotonic entry IDs double as the sequence numbers callers
+// pass back as lastSeq.
+//
+// The stream append happens first and is the only failure that aborts the
+// turn: a socket write can fail the instant the client's connection drops,
+// which is exactly the scenario HandleResume exists for, so that failure is
+// logged and swallowed rather than propagated. Generation keeps running and
+// every subsequent frame keeps landing in the stream for the client to pick
+// up on reconnect.
+func (ps *PromptSession) publishFrame(ctx context.Context, kind byte, data []byte) error {
+	if err := ps.redis.XAdd(ctx, &redis.XAddArgs{
+		Stream: sessionStreamKey(ps.threadId),
+		Values: map[string]any{"kind": string(kind), "data": data},
+	}).Err(); err != nil {
+		return err
+	}
+	if err := ps.conn.Write(ctx, websocket.MessageText, append([]byte{kind}, data...)); err != nil {
+		log.Printf("write to websocket failed, continuing (frame buffered for resume): %v\n", err)
+		return nil
+	}
+	ps.writeDeadline.reset(config.GetConfig().WriteIdleTimeout)
+	return nil
+}
+
+// endStream marks the session's stream as finished and lets it expire
+// after a grace period, giving a client that's mid-reconnect time to catch
+// the tail end of the conversation.
+func (ps *PromptSession) endStream(ctx context.Context) error {
+	key := sessionStreamKey(ps.threadId)
+	if err := ps.redis.XAdd(ctx, &redis.XAddArgs{
+		Stream: key,
+		Values: map[string]any{"kind": string(frameEnd)},
+	}).Err(); err != nil {
+		return err
+	}
+	return ps.redis.Expire(ctx, key, resumeGracePeriod()).Err()
+}
+
+func resumeGracePeriod() time.Duration {
+	if d := config.GetConfig().ResumeGracePeriod; d > 0 {
+		return d
+	}
+	return 2 * time.Minute
+}
+
+// HandleResume serves ?resumeSessionId=<threadId>&lastSeq=<streamId> by
+// replaying any frames the client missed from the Redis Stream a live (or
+// recently finished) PromptSession.Run published, then attaching to the
+// live stream until it ends. It lets a client whose socket dropped
+// mid-generation rejoin without losing partial output or re-running any
+// function calls.
+func HandleResume(ctx context.Context, redisClient *redis.Client, rw http.ResponseWriter, r *http.Request) error {
+	resumeSessionId := r.URL.Query().Get("resumeSessionId")
+	if resumeSessionId == "" {
+		return errors.New("resume: missing resumeSessionId")
+	}
+	threadId, err := uuid.Parse(resumeSessionId)
+	if err != nil {
+		return fmt.Errorf("resume: invalid resumeSessionId: %w", err)
+	}
+	lastSeq := r.URL.Query().Get("lastSeq")
+	if lastSeq == "" {
+		lastSeq = "0"
+	}
+
+	conn, err := websocket.Accept(rw, r, &websocket.AcceptOptions{
+		OriginPatterns:     []string{"null"},
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		return err
+	}
+	defer conn.Close(websocket.StatusNormalClosure, "")
+
+	key := sessionStreamKey(threadId)
+	lastSeq, done, err := replayMissedFrames(ctx, redisClient, conn, key, lastSeq)
+	if err != nil {
+		return err
+	}
+	if done {
+		return nil
+	}
+	return tailLiveFrames(ctx, redisClient, conn, key, lastSeq)
+}
+
+// replayMissedFrames writes every frame after lastSeq to conn. It returns
+// the new lastSeq and whether the stream had already ended.
+func replayMissedFrames(ctx context.Context, redisClient *redis.Client, conn *websocket.Conn, key, lastSeq string) (string, bool, error) {
+	entries, err := redisClient.XRange(ctx, key, "("+lastSeq, "+").Result()
+	if err != nil {
+		return lastSeq, false, err
+	}
+	for _, entry := range entries {
+		lastSeq = entry.ID
+		done, err := writeStreamEntry(ctx, conn, entry)
+		if err != nil || done {
+			return lastSeq, done, err
+		}
+	}
+	return lastSeq, false, nil
+}
+
+// tailLiveFrames blocks on new entries past lastSeq until the stream ends
+// or ctx is cancelled.
+func tailLiveFrames(ctx context.Context, redisClient *redis.Client, conn *websocket.Conn, key, lastSeq string) error {
+	for {
+		results, err := redisClient.XRead(ctx, &redis.XReadArgs{
+			Streams: []string{key, lastSeq},
+			Block:   0,
+		}).Result()
+		if err != nil {
+			return err
+		}
+		for _, stream := range results {
+			for _, entry := range stream.Messages {
+				lastSeq = entry.ID
+				done, err := writeStreamEntry(ctx, conn, entry)
+				if err != nil || done {
+					return err
+				}
+			}
+		}
+	}
+}
+
+func writeStreamEntry(ctx context.Context, conn *websocket.Conn, entry redis.XMessage) (bool, error) {
+	kind, _ := entry.Values["kind"].(string)
+	if kind == string(frameEnd) {
+		return true, nil
+	}
+	data, _ := entry.Values["data"].(string)
+	if err := conn.Write(ctx, websocket.MessageText, append([]byte(kind), data...)); err != nil {
+		return false, err
+	}
+	return false, nil
+}
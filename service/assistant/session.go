@@ -25,11 +25,16 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/Katharine/tiny-assistant/service/assistant/backend"
 	"github.com/Katharine/tiny-assistant/service/assistant/config"
 	"github.com/Katharine/tiny-assistant/service/assistant/functions"
+	"github.com/Katharine/tiny-assistant/service/assistant/metrics"
 	"github.com/Katharine/tiny-assistant/service/assistant/query"
 	"github.com/redis/go-redis/v9"
-	"google.golang.org/api/iterator"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/genai"
 	"nhooyr.io/websocket"
 )
@@ -38,9 +43,14 @@ type PromptSession struct {
 	conn             *websocket.Conn
 	prompt           string
 	query            url.Values
+	header           http.Header
 	redis            *redis.Client
 	threadId         uuid.UUID
 	originalThreadId string
+
+	readDeadline  *deadlineTimer
+	writeDeadline *deadlineTimer
+	turnDeadline  *deadlineTimer
 }
 
 type QueryContext struct {
@@ -62,23 +72,70 @@ func NewPromptSession(redisClient *redis.Client, rw http.ResponseWriter, r *http
 		conn:             c,
 		prompt:           prompt,
 		query:            r.URL.Query(),
+		header:           r.Header,
 		redis:            redisClient,
 		threadId:         uuid.New(),
 		originalThreadId: originalThreadId,
 	}, nil
 }
 
+// backendProvider builds the backend.Provider selected by the "backend"
+// query parameter (default "gemini"), also returning its name for metrics
+// and span attributes. The "backendAddr" parameter is passed through as the
+// provider selector, e.g. the dial address for "grpc".
+func (ps *PromptSession) backendProvider() (backend.Provider, string, error) {
+	name := ps.query.Get("backend")
+	if name == "" {
+		name = "gemini"
+	}
+	selector := ps.query.Get("backendAddr")
+	if name == "gemini" && selector == "" {
+		selector = config.GetConfig().GeminiKey
+	}
+	provider, err := backend.Get(name, selector)
+	return provider, name, err
+}
+
 func (ps *PromptSession) Run(ctx context.Context) {
+	ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(ps.header))
 	ctx = query.ContextWith(ctx, ps.query)
-	geminiClient, err := genai.NewClient(ctx, &genai.ClientConfig{
-		APIKey:  config.GetConfig().GeminiKey,
-		Backend: genai.BackendGeminiAPI,
-	})
+	provider, providerName, err := ps.backendProvider()
 	if err != nil {
-		log.Printf("error creating Gemini client: %v\n", err)
+		log.Printf("error creating backend provider: %v\n", err)
 		_ = ps.conn.Close(websocket.StatusInternalError, "Error creating client.")
 		return
 	}
+	defer func() {
+		if err := provider.Close(); err != nil {
+			log.Printf("error closing backend provider: %v\n", err)
+		}
+	}()
+
+	start := time.Now()
+	defer func() { metrics.ObserveConnectionDuration(time.Since(start)) }()
+
+	cfg := config.GetConfig()
+	ps.readDeadline = newDeadlineTimer(cfg.ReadIdleTimeout)
+	ps.writeDeadline = newDeadlineTimer(cfg.WriteIdleTimeout)
+	defer ps.readDeadline.stop()
+	defer ps.writeDeadline.stop()
+	defer func() { _ = ps.endStream(context.Background()) }()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-ps.readDeadline.C():
+			log.Println("idle read timeout exceeded, closing connection")
+			_ = ps.conn.Close(websocket.StatusPolicyViolation, "idle timeout")
+			cancel()
+		case <-ps.writeDeadline.C():
+			log.Println("idle write timeout exceeded, closing connection")
+			_ = ps.conn.Close(websocket.StatusPolicyViolation, "idle timeout")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
 
 	var messages []*genai.Content
 	messages = append(messages, &genai.Content{
@@ -86,77 +143,95 @@ func (ps *PromptSession) Run(ctx context.Context) {
 		Role:  "user",
 	})
 
+	totalInputTokens := 0
+	totalOutputTokens := 0
+	iterations := 0
+	defer func() { metrics.ObserveIterations(iterations) }()
+	baseMessageCount := 0
 	if ps.originalThreadId != "" {
-		oldMessages, err := ps.restoreThread(ctx, ps.originalThreadId)
+		oldMessages, priorUsage, err := ps.restoreThread(ctx, ps.originalThreadId)
 		if err != nil {
 			log.Printf("error restoring thread: %v\n", err)
 			_ = ps.conn.Close(websocket.StatusInternalError, "Error restoring thread.")
 			return
-		} else {
-			messages = append(oldMessages, messages...)
 		}
+		messages = append(oldMessages, messages...)
+		baseMessageCount = len(oldMessages)
+		totalInputTokens = priorUsage.InputTokens
+		totalOutputTokens = priorUsage.OutputTokens
+		iterations = priorUsage.Iterations
 	}
-	totalInputTokens := 0
-	totalOutputTokens := 0
-	iterations := 0
+
+	// Tell the client its threadId before generation starts, not just in
+	// the terminal frame: a socket that drops mid-turn still needs it to
+	// reconnect via ?resumeSessionId=<threadId>.
+	if err := ps.publishFrame(ctx, 't', []byte(ps.threadId.String())); err != nil {
+		log.Printf("failed to announce thread id: %v\n", err)
+	}
+
 	for {
 		cont, err := func() (bool, error) {
 			iterations++
-			var tools []*genai.Tool
+			var tools []backend.Tool
 			if iterations <= 10 {
-				tools = []*genai.Tool{{FunctionDeclarations: functions.GetFunctionDefinitionsForCapabilities(query.SupportedActionsFromContext(ctx))}}
+				tools = toBackendTools(functions.GetFunctionDefinitionsForCapabilities(query.SupportedActionsFromContext(ctx)))
 			}
 			streamCtx := ctx
 
-			temperature := float64(0.5)
-			one := int64(1)
-			s := geminiClient.Models.GenerateContentStream(streamCtx, "models/gemini-2.0-flash", messages, &genai.GenerateContentConfig{
-				SystemInstruction: &genai.Content{Parts: []*genai.Part{{Text: ps.generateSystemPrompt(streamCtx)}}},
-				Temperature:       &temperature,
-				CandidateCount:    &one,
-				Tools:             tools,
-			})
+			ps.turnDeadline = newDeadlineTimer(cfg.TurnBudget)
+			defer ps.turnDeadline.stop()
+
+			streamCtx, generateSpan := tracer.Start(streamCtx, "assistant.generate", trace.WithAttributes(
+				attribute.String("model", providerName),
+				attribute.String("thread.id", ps.threadId.String()),
+				attribute.Int("iteration", iterations),
+			))
+			defer generateSpan.End()
+
+			s, err := provider.StreamGenerate(streamCtx, toBackendMessages(messages), tools, ps.generateSystemPrompt(streamCtx))
+			if err != nil {
+				generateSpan.RecordError(err)
+				log.Printf("starting generation failed: %v\n", err)
+				_ = ps.conn.Close(websocket.StatusInternalError, "request to backend failed")
+				return false, err
+			}
 			var functionCall *genai.FunctionCall
 			content := ""
-			var usageData *genai.GenerateContentResponseUsageMetadata
-			for resp, err := range s {
-				if errors.Is(err, iterator.Done) {
-					break
-				}
-				if err != nil {
-					log.Printf("recv from Google failed: %v\n", err)
-					_ = ps.conn.Close(websocket.StatusInternalError, "request to Google failed")
-					return false, err
-				}
-				usageData = resp.UsageMetadata
-				if len(resp.Candidates) == 0 {
-					continue
-				}
-				choice := resp.Candidates[0]
-				ourContent := ""
-				for _, c := range choice.Content.Parts {
-					if c.Text != "" {
-						ourContent += c.Text
+		streamLoop:
+			for {
+				select {
+				case chunk, ok := <-s:
+					if !ok {
+						break streamLoop
 					}
-					if c.FunctionCall != nil {
-						fc := *c.FunctionCall
-						functionCall = &fc
+					ps.readDeadline.reset(cfg.ReadIdleTimeout)
+					if chunk.Err != nil {
+						generateSpan.RecordError(chunk.Err)
+						log.Printf("recv from backend failed: %v\n", chunk.Err)
+						_ = ps.conn.Close(websocket.StatusInternalError, "request to backend failed")
+						return false, chunk.Err
 					}
-				}
-				if strings.TrimSpace(ourContent) != "" {
-					if err := ps.conn.Write(streamCtx, websocket.MessageText, []byte("c"+ourContent)); err != nil {
-						log.Printf("write to websocket failed: %v\n", err)
-						break
+					if chunk.Usage != nil {
+						totalInputTokens += chunk.Usage.InputTokens
+						totalOutputTokens += chunk.Usage.OutputTokens
+						metrics.RecordTokens(providerName, chunk.Usage.InputTokens, chunk.Usage.OutputTokens)
 					}
-				}
-				content += ourContent
-			}
-			if usageData != nil {
-				if usageData.PromptTokenCount != nil {
-					totalInputTokens += int(*usageData.PromptTokenCount)
-				}
-				if usageData.CandidatesTokenCount != nil {
-					totalOutputTokens += int(*usageData.CandidatesTokenCount)
+					if chunk.FunctionCall != nil {
+						functionCall = &genai.FunctionCall{Name: chunk.FunctionCall.Name, Args: chunk.FunctionCall.Args}
+					}
+					if strings.TrimSpace(chunk.Text) != "" {
+						if err := ps.publishFrame(streamCtx, 'c', []byte(chunk.Text)); err != nil {
+							log.Printf("publish frame failed: %v\n", err)
+							break streamLoop
+						}
+					}
+					content += chunk.Text
+				case <-ps.turnDeadline.C():
+					log.Println("turn budget exceeded, closing connection")
+					_ = ps.conn.Close(websocket.StatusPolicyViolation, "turn budget exceeded")
+					return false, errors.New("turn budget exceeded")
+				case <-ctx.Done():
+					return false, ctx.Err()
 				}
 			}
 			if len(strings.TrimSpace(content)) > 0 {
@@ -175,21 +250,34 @@ func (ps *PromptSession) Run(ctx context.Context) {
 				log.Printf("calling function %s\n", functionCall.Name)
 				fnBytes, _ := json.Marshal(functionCall.Args)
 				fnArgs := string(fnBytes)
-				if err := ps.conn.Write(ctx, websocket.MessageText, []byte("f"+functions.SummariseFunction(functionCall.Name, fnArgs))); err != nil {
-					log.Printf("write to websocket failed: %v\n", err)
+				if err := ps.publishFrame(ctx, 'f', []byte(functions.SummariseFunction(functionCall.Name, fnArgs))); err != nil {
+					log.Printf("publish frame failed: %v\n", err)
 					return false, err
 				}
+				ps.ExtendTurnDeadline(cfg.FunctionCallBudget)
+				ps.ExtendWriteDeadline(cfg.FunctionCallBudget)
+				ps.ExtendReadDeadline(cfg.FunctionCallBudget)
+				fnCtx, fnSpan := tracer.Start(ctx, "assistant.function_call", trace.WithAttributes(
+					attribute.String("function.name", functionCall.Name),
+					attribute.String("model", providerName),
+					attribute.String("thread.id", ps.threadId.String()),
+					attribute.Int("iteration", iterations),
+				))
+				fnStart := time.Now()
 				var result string
 				var err error
 				if functions.IsAction(functionCall.Name) {
-					result, err = functions.CallAction(ctx, functionCall.Name, fnArgs, ps.conn)
+					result, err = functions.CallAction(fnCtx, functionCall.Name, fnArgs, ps.conn)
 				} else {
-					result, err = functions.CallFunction(ctx, functionCall.Name, fnArgs)
+					result, err = functions.CallFunction(fnCtx, functionCall.Name, fnArgs)
 				}
+				metrics.ObserveFunctionCall(functionCall.Name, time.Since(fnStart), err)
 				if err != nil {
+					fnSpan.RecordError(err)
 					log.Printf("call function failed: %v\n", err)
 					result = "failed to call function: " + err.Error()
 				}
+				fnSpan.End()
 				var mapResult map[string]any
 				_ = json.Unmarshal([]byte(result), &mapResult)
 				messages = append(messages, &genai.Content{
@@ -203,8 +291,8 @@ func (ps *PromptSession) Run(ctx context.Context) {
 				})
 				return true, nil
 			} else {
-				if err := ps.conn.Write(ctx, websocket.MessageText, []byte("d")); err != nil {
-					log.Printf("write to websocket failed: %v\n", err)
+				if err := ps.publishFrame(ctx, 'd', nil); err != nil {
+					log.Printf("publish frame failed: %v\n", err)
 					return false, err
 				}
 			}
@@ -219,56 +307,15 @@ func (ps *PromptSession) Run(ctx context.Context) {
 		}
 		log.Println("Going around again")
 	}
-	if err := ps.storeThread(ctx, messages); err != nil {
+	usage := ThreadUsage{InputTokens: totalInputTokens, OutputTokens: totalOutputTokens, Iterations: iterations}
+	if err := ps.storeThread(ctx, messages, baseMessageCount, usage); err != nil {
 		log.Printf("store thread failed: %v\n", err)
 		_ = ps.conn.Close(websocket.StatusInternalError, "store thread failed")
 		return
 	}
-	if err := ps.conn.Write(ctx, websocket.MessageText, []byte("t"+ps.threadId.String())); err != nil {
+	if err := ps.publishFrame(ctx, 't', []byte(ps.threadId.String())); err != nil {
 		log.Printf("store thread ID failed: %s\n", err)
 	}
 	log.Println("Request handled successfully.")
 	_ = ps.conn.Close(websocket.StatusNormalClosure, "")
 }
-
-type SerializedMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
-}
-
-func (ps *PromptSession) storeThread(ctx context.Context, messages []*genai.Content) error {
-	var toStore []SerializedMessage
-	for _, m := range messages {
-		if len(m.Parts) != 0 && (m.Role == "user" || m.Role == "model") && len(strings.TrimSpace(m.Parts[0].Text)) > 0 {
-			toStore = append(toStore, SerializedMessage{
-				Content: m.Parts[0].Text,
-				Role:    m.Role,
-			})
-		}
-	}
-	j, err := json.Marshal(toStore)
-	if err != nil {
-		return err
-	}
-	ps.redis.Set(ctx, "thread:"+ps.threadId.String(), j, 10*time.Minute)
-	return nil
-}
-
-func (ps *PromptSession) restoreThread(ctx context.Context, oldThreadId string) ([]*genai.Content, error) {
-	j, err := ps.redis.Get(ctx, "thread:"+oldThreadId).Result()
-	if err != nil {
-		return nil, err
-	}
-	var messages []SerializedMessage
-	if err := json.Unmarshal([]byte(j), &messages); err != nil {
-		return nil, err
-	}
-	var result []*genai.Content
-	for _, m := range messages {
-		result = append(result, &genai.Content{
-			Parts: []*genai.Part{{Text: m.Content}},
-			Role:  m.Role,
-		})
-	}
-	return result, nil
-}
@@ -0,0 +1,268 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package assistant
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/Katharine/tiny-assistant/service/assistant/backend"
+	"github.com/Katharine/tiny-assistant/service/assistant/config"
+	"github.com/Katharine/tiny-assistant/service/assistant/metrics"
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/genai"
+)
+
+// threadSchemaVersion is bumped whenever SerializedThread's on-disk shape
+// changes. restoreThread uses it to pick the right migration path.
+const threadSchemaVersion = 2
+
+// ThreadUsage is the token/iteration accounting carried alongside a stored
+// thread so a resumed conversation keeps accruing against the same totals.
+type ThreadUsage struct {
+	InputTokens  int `json:"inputTokens"`
+	OutputTokens int `json:"outputTokens"`
+	Iterations   int `json:"iterations"`
+}
+
+// SerializedPart is one part of a SerializedMessage. Kind says which of the
+// other fields is populated; this mirrors backend.Part so a provider's
+// function calls and inline data survive a store/restore round trip.
+type SerializedPart struct {
+	Kind             backend.PartKind          `json:"kind"`
+	Text             string                    `json:"text,omitempty"`
+	FunctionCall     *backend.FunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *backend.FunctionResponse `json:"functionResponse,omitempty"`
+	InlineData       *backend.InlineData       `json:"inlineData,omitempty"`
+}
+
+// SerializedMessage is one persisted conversation turn.
+type SerializedMessage struct {
+	Role      string           `json:"role"`
+	Parts     []SerializedPart `json:"parts"`
+	Timestamp time.Time        `json:"timestamp"`
+}
+
+// SerializedThread is the full value stored under "thread:<id>".
+type SerializedThread struct {
+	SchemaVersion int                 `json:"schemaVersion"`
+	Messages      []SerializedMessage `json:"messages"`
+	Usage         ThreadUsage         `json:"usage"`
+}
+
+// legacySerializedMessage is the pre-versioning shape (a bare JSON array),
+// which only ever kept Parts[0].Text for user/model turns.
+type legacySerializedMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+func toSerializedMessages(messages []*genai.Content, now time.Time) []SerializedMessage {
+	result := make([]SerializedMessage, 0, len(messages))
+	for _, m := range messages {
+		parts := make([]SerializedPart, 0, len(m.Parts))
+		for _, p := range m.Parts {
+			switch {
+			case p.Text != "":
+				parts = append(parts, SerializedPart{Kind: backend.PartText, Text: p.Text})
+			case p.FunctionCall != nil:
+				parts = append(parts, SerializedPart{
+					Kind:         backend.PartFunctionCall,
+					FunctionCall: &backend.FunctionCall{Name: p.FunctionCall.Name, Args: p.FunctionCall.Args},
+				})
+			case p.FunctionResponse != nil:
+				parts = append(parts, SerializedPart{
+					Kind: backend.PartFunctionResponse,
+					FunctionResponse: &backend.FunctionResponse{
+						Name:     p.FunctionResponse.Name,
+						Response: p.FunctionResponse.Response,
+					},
+				})
+			case p.InlineData != nil:
+				parts = append(parts, SerializedPart{
+					Kind:       backend.PartInlineData,
+					InlineData: &backend.InlineData{MimeType: p.InlineData.MIMEType, Data: p.InlineData.Data},
+				})
+			}
+		}
+		result = append(result, SerializedMessage{Role: m.Role, Parts: parts, Timestamp: now})
+	}
+	return result
+}
+
+func fromSerializedMessages(messages []SerializedMessage) []*genai.Content {
+	result := make([]*genai.Content, 0, len(messages))
+	for _, m := range messages {
+		parts := make([]*genai.Part, 0, len(m.Parts))
+		for _, p := range m.Parts {
+			switch p.Kind {
+			case backend.PartText:
+				parts = append(parts, &genai.Part{Text: p.Text})
+			case backend.PartFunctionCall:
+				parts = append(parts, &genai.Part{FunctionCall: &genai.FunctionCall{Name: p.FunctionCall.Name, Args: p.FunctionCall.Args}})
+			case backend.PartFunctionResponse:
+				parts = append(parts, &genai.Part{FunctionResponse: &genai.FunctionResponse{Name: p.FunctionResponse.Name, Response: p.FunctionResponse.Response}})
+			case backend.PartInlineData:
+				parts = append(parts, &genai.Part{InlineData: &genai.Blob{MIMEType: p.InlineData.MimeType, Data: p.InlineData.Data}})
+			}
+		}
+		result = append(result, &genai.Content{Role: m.Role, Parts: parts})
+	}
+	return result
+}
+
+func migrateLegacy(legacy []legacySerializedMessage) SerializedThread {
+	messages := make([]SerializedMessage, 0, len(legacy))
+	for _, m := range legacy {
+		if strings.TrimSpace(m.Content) == "" {
+			continue
+		}
+		messages = append(messages, SerializedMessage{
+			Role:  m.Role,
+			Parts: []SerializedPart{{Kind: backend.PartText, Text: m.Content}},
+		})
+	}
+	return SerializedThread{SchemaVersion: threadSchemaVersion, Messages: messages}
+}
+
+func threadTTL() time.Duration {
+	if ttl := config.GetConfig().ThreadTTL; ttl > 0 {
+		return ttl
+	}
+	return 10 * time.Minute
+}
+
+// storeThread persists messages (the full conversation, including any
+// history restored from originalThreadId) under ps.threadId. baseCount is
+// how many of messages were already persisted under originalThreadId; when
+// Redis Streams storage is enabled only the messages beyond it are
+// appended, so long threads aren't rewritten in full on every turn.
+func (ps *PromptSession) storeThread(ctx context.Context, messages []*genai.Content, baseCount int, usage ThreadUsage) error {
+	now := time.Now()
+	if config.GetConfig().ThreadStreamEnabled {
+		return ps.appendThreadStream(ctx, toSerializedMessages(messages[baseCount:], now), usage)
+	}
+	thread := SerializedThread{
+		SchemaVersion: threadSchemaVersion,
+		Messages:      toSerializedMessages(messages, now),
+		Usage:         usage,
+	}
+	j, err := json.Marshal(thread)
+	if err != nil {
+		return err
+	}
+	return ps.redis.Set(ctx, "thread:"+ps.threadId.String(), j, threadTTL()).Err()
+}
+
+func (ps *PromptSession) restoreThread(ctx context.Context, oldThreadId string) ([]*genai.Content, ThreadUsage, error) {
+	messages, usage, err := ps.doRestoreThread(ctx, oldThreadId)
+	metrics.RecordThreadRestore(err == nil)
+	return messages, usage, err
+}
+
+func (ps *PromptSession) doRestoreThread(ctx context.Context, oldThreadId string) ([]*genai.Content, ThreadUsage, error) {
+	if config.GetConfig().ThreadStreamEnabled {
+		if messages, usage, err := ps.restoreThreadStream(ctx, oldThreadId); err == nil {
+			return messages, usage, nil
+		}
+		// Fall through: the thread may predate streaming having been enabled.
+	}
+
+	j, err := ps.redis.Get(ctx, "thread:"+oldThreadId).Result()
+	if err != nil {
+		return nil, ThreadUsage{}, err
+	}
+
+	var thread SerializedThread
+	if err := json.Unmarshal([]byte(j), &thread); err == nil && thread.SchemaVersion > 0 {
+		return fromSerializedMessages(thread.Messages), thread.Usage, nil
+	}
+
+	var legacy []legacySerializedMessage
+	if err := json.Unmarshal([]byte(j), &legacy); err != nil {
+		return nil, ThreadUsage{}, fmt.Errorf("restoreThread: unrecognised schema: %w", err)
+	}
+	thread = migrateLegacy(legacy)
+	return fromSerializedMessages(thread.Messages), thread.Usage, nil
+}
+
+// appendThreadStream adds newMessages to the Redis Stream backing
+// oldThreadId/ps.threadId instead of rewriting the whole thread. The stream
+// key tracks ps.threadId directly so a chain of resumed turns shares one
+// ever-growing log.
+func (ps *PromptSession) appendThreadStream(ctx context.Context, newMessages []SerializedMessage, usage ThreadUsage) error {
+	key := "threadlog:" + ps.threadId.String()
+	for _, m := range newMessages {
+		j, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		if err := ps.redis.XAdd(ctx, &redis.XAddArgs{
+			Stream: key,
+			Values: map[string]any{"message": j},
+		}).Err(); err != nil {
+			return err
+		}
+	}
+	usageJSON, err := json.Marshal(usage)
+	if err != nil {
+		return err
+	}
+	if err := ps.redis.Set(ctx, key+":usage", usageJSON, threadTTL()).Err(); err != nil {
+		return err
+	}
+	return ps.redis.Expire(ctx, key, threadTTL()).Err()
+}
+
+func (ps *PromptSession) restoreThreadStream(ctx context.Context, oldThreadId string) ([]*genai.Content, ThreadUsage, error) {
+	key := "threadlog:" + oldThreadId
+	entries, err := ps.redis.XRange(ctx, key, "-", "+").Result()
+	if err != nil {
+		return nil, ThreadUsage{}, err
+	}
+	if len(entries) == 0 {
+		return nil, ThreadUsage{}, fmt.Errorf("restoreThreadStream: no entries for %s", oldThreadId)
+	}
+	messages := make([]SerializedMessage, 0, len(entries))
+	for _, e := range entries {
+		raw, ok := e.Values["message"].(string)
+		if !ok {
+			continue
+		}
+		var m SerializedMessage
+		if err := json.Unmarshal([]byte(raw), &m); err != nil {
+			return nil, ThreadUsage{}, err
+		}
+		messages = append(messages, m)
+	}
+	var usage ThreadUsage
+	if usageJSON, err := ps.redis.Get(ctx, key+":usage").Result(); err == nil {
+		_ = json.Unmarshal([]byte(usageJSON), &usage)
+	}
+	// ps.threadId carries this turn's log forward from oldThreadId's.
+	ps.threadId = mustParseOrKeep(oldThreadId, ps.threadId)
+	return fromSerializedMessages(messages), usage, nil
+}
+
+func mustParseOrKeep(s string, fallback uuid.UUID) uuid.UUID {
+	if parsed, err := uuid.Parse(s); err == nil {
+		return parsed
+	}
+	return fallback
+}
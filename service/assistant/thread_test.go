@@ -0,0 +1,65 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package assistant
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/genai"
+)
+
+func TestSerializeDeserializeRoundTrip(t *testing.T) {
+	original := []*genai.Content{
+		{Role: "user", Parts: []*genai.Part{{Text: "what's the weather in Boston?"}}},
+		{Role: "model", Parts: []*genai.Part{{FunctionCall: &genai.FunctionCall{
+			Name: "get_weather",
+			Args: map[string]any{"city": "Boston"},
+		}}}},
+		{Role: "function", Parts: []*genai.Part{{FunctionResponse: &genai.FunctionResponse{
+			Name:     "get_weather",
+			Response: map[string]any{"tempF": float64(72)},
+		}}}},
+		{Role: "model", Parts: []*genai.Part{{Text: "It's 72F in Boston."}}},
+	}
+
+	serialized := toSerializedMessages(original, time.Unix(0, 0))
+	restored := fromSerializedMessages(serialized)
+
+	if !reflect.DeepEqual(original, restored) {
+		t.Fatalf("round trip mismatch:\n got: %#v\nwant: %#v", restored, original)
+	}
+}
+
+func TestMigrateLegacySkipsEmptyContent(t *testing.T) {
+	legacy := []legacySerializedMessage{
+		{Role: "user", Content: "hello"},
+		{Role: "model", Content: ""},
+		{Role: "model", Content: "hi there"},
+	}
+
+	thread := migrateLegacy(legacy)
+
+	if thread.SchemaVersion != threadSchemaVersion {
+		t.Fatalf("expected migrated thread to carry the current schema version, got %d", thread.SchemaVersion)
+	}
+	if len(thread.Messages) != 2 {
+		t.Fatalf("expected blank legacy messages to be dropped, got %d messages", len(thread.Messages))
+	}
+	if thread.Messages[0].Parts[0].Text != "hello" || thread.Messages[1].Parts[0].Text != "hi there" {
+		t.Fatalf("unexpected migrated content: %#v", thread.Messages)
+	}
+}